@@ -0,0 +1,98 @@
+// Package event provides a minimal one-to-many subscription
+// primitive, modeled on go-ethereum's event.Feed. It's used
+// throughout protocol so that consumers (indexers, mempool GC, RPC
+// waiters) can subscribe to chain activity without sharing a single
+// synchronous callback path.
+package event
+
+import (
+	"sync"
+
+	"chain/errors"
+)
+
+// ErrSubscriptionDropped is sent on a Subscription's Err channel when
+// its consumer fell too far behind and was dropped.
+var ErrSubscriptionDropped = errors.New("event: subscription dropped, consumer too slow")
+
+// Subscription represents a subscription to a Feed. Consumers must
+// call Unsubscribe once they're no longer interested, and should
+// select on Err to notice if they were dropped for being slow.
+type Subscription interface {
+	Unsubscribe()
+	Err() <-chan error
+}
+
+// Feed implements one-to-many delivery of values of type T to
+// subscribed channels. Unlike a bare channel, a Feed never blocks its
+// sender: a subscriber whose channel is full is dropped instead.
+type Feed[T any] struct {
+	mu   sync.Mutex
+	subs map[*subscription[T]]struct{}
+}
+
+// Subscribe registers ch to receive every value sent on the feed
+// from this point on. ch should be buffered; how much backpressure a
+// subscriber can tolerate before being dropped is exactly its own
+// channel's capacity.
+func (f *Feed[T]) Subscribe(ch chan<- T) Subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[*subscription[T]]struct{})
+	}
+	sub := &subscription[T]{feed: f, ch: ch, err: make(chan error, 1)}
+	f.subs[sub] = struct{}{}
+	return sub
+}
+
+// Send delivers value to every current subscriber without blocking.
+// A subscriber whose channel is full is unsubscribed immediately and
+// sent ErrSubscriptionDropped on its Err channel.
+func (f *Feed[T]) Send(value T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subs {
+		select {
+		case sub.ch <- value:
+		default:
+			f.dropLocked(sub)
+		}
+	}
+}
+
+func (f *Feed[T]) dropLocked(sub *subscription[T]) {
+	delete(f.subs, sub)
+	select {
+	case sub.err <- ErrSubscriptionDropped:
+	default:
+	}
+	close(sub.err)
+}
+
+func (f *Feed[T]) unsubscribe(sub *subscription[T]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[sub]; !ok {
+		return
+	}
+	delete(f.subs, sub)
+	close(sub.err)
+}
+
+type subscription[T any] struct {
+	feed *Feed[T]
+	ch   chan<- T
+	err  chan error
+	once sync.Once
+}
+
+func (s *subscription[T]) Unsubscribe() {
+	s.once.Do(func() {
+		s.feed.unsubscribe(s)
+	})
+}
+
+func (s *subscription[T]) Err() <-chan error {
+	return s.err
+}
@@ -0,0 +1,53 @@
+package event
+
+import "testing"
+
+func TestFeedSendReceive(t *testing.T) {
+	var f Feed[int]
+	ch := make(chan int, 1)
+	sub := f.Subscribe(ch)
+	defer sub.Unsubscribe()
+
+	f.Send(7)
+	if got := <-ch; got != 7 {
+		t.Errorf("got %d, want 7", got)
+	}
+}
+
+func TestFeedDropsSlowSubscriber(t *testing.T) {
+	var f Feed[int]
+	ch := make(chan int, 1)
+	sub := f.Subscribe(ch)
+
+	f.Send(1) // fills the buffer
+	f.Send(2) // subscriber hasn't drained yet; should be dropped
+
+	select {
+	case err := <-sub.Err():
+		if err != ErrSubscriptionDropped {
+			t.Errorf("got err %v, want ErrSubscriptionDropped", err)
+		}
+	default:
+		t.Fatal("expected subscriber to be dropped")
+	}
+
+	// A further Send shouldn't panic or deliver to the dropped sub.
+	f.Send(3)
+	if len(ch) != 1 {
+		t.Fatalf("expected buffered channel to still hold just the first value, got %d items", len(ch))
+	}
+}
+
+func TestFeedUnsubscribe(t *testing.T) {
+	var f Feed[int]
+	ch := make(chan int, 1)
+	sub := f.Subscribe(ch)
+	sub.Unsubscribe()
+
+	f.Send(1)
+	select {
+	case v := <-ch:
+		t.Fatalf("unsubscribed channel received %d", v)
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+package protocol
+
+import (
+	"chain/protocol/bc"
+	"chain/protocol/event"
+)
+
+// ReorgEvent describes a fork-choice switch: reverted lists the
+// abandoned blocks in tip-to-ancestor order, applied lists the newly
+// canonical blocks in ancestor-to-tip order. It's the payload sent on
+// a Chain's ReorgFeed.
+type ReorgEvent struct {
+	Reverted []*bc.Block
+	Applied  []*bc.Block
+}
+
+// RemoveReason explains why a transaction left the pending pool
+// without a caller asking for its removal.
+type RemoveReason int
+
+const (
+	// RemoveCommitted means the tx was included in a committed block.
+	RemoveCommitted RemoveReason = iota
+	// RemoveExpired means the tx's time-to-live elapsed. No Pool
+	// implementation in this package tracks per-tx insertion time yet,
+	// so nothing in this package emits RemoveExpired today; it's
+	// reserved for a future Pool that does.
+	RemoveExpired
+	// RemoveConflict means the pending block a local proposer was
+	// assembling got discarded, and tx -- part of that discarded
+	// block -- no longer applies now that a conflicting tx (one
+	// spending the same output) has landed on chain instead.
+	RemoveConflict
+)
+
+// RemovedTx is the payload sent on a Chain's RemovedTxFeed.
+type RemovedTx struct {
+	Tx     *bc.Tx
+	Reason RemoveReason
+}
+
+// WitnessEvent is the payload sent on a Chain's WitnessFeed: the
+// witness data for Header, which light peers can use to verify it
+// without downloading the full block.
+type WitnessEvent struct {
+	Header  *bc.BlockHeader
+	Witness WitnessData
+}
+
+// TxFilter reports whether tx is of interest to a PendingTxFeed
+// subscriber. RPC layers can use it to subscribe only to txs
+// affecting a given asset ID or account control-program prefix,
+// rather than polling the whole feed.
+type TxFilter func(*bc.Tx) bool
+
+// feeds holds every typed event.Feed a Chain publishes to. It's kept
+// as its own struct, embedded by value, so NewChain doesn't need to
+// zero-initialize each Feed individually -- the zero value of
+// event.Feed is ready to use.
+type feeds struct {
+	newHead   event.Feed[*bc.Block]
+	newFinal  event.Feed[*bc.Block]
+	reorg     event.Feed[ReorgEvent]
+	pendingTx event.Feed[*bc.Tx]
+	removedTx event.Feed[RemovedTx]
+	witness   event.Feed[WitnessEvent]
+}
+
+// NewHeadFeed publishes the new canonical tip every time it changes,
+// whether by simple extension or by reorg.
+func (c *Chain) NewHeadFeed() *event.Feed[*bc.Block] { return &c.feeds.newHead }
+
+// NewFinalizedFeed publishes a block every time FinalizeBlock commits
+// to it, after which it can no longer be reverted.
+func (c *Chain) NewFinalizedFeed() *event.Feed[*bc.Block] { return &c.feeds.newFinal }
+
+// ReorgFeed publishes once per fork-choice switch away from the
+// previous canonical head.
+func (c *Chain) ReorgFeed() *event.Feed[ReorgEvent] { return &c.feeds.reorg }
+
+// PendingTxFeed publishes every tx as it's admitted to the pending
+// pool. Most callers should use SubscribePendingTxs instead, which
+// applies a TxFilter server-side.
+func (c *Chain) PendingTxFeed() *event.Feed[*bc.Tx] { return &c.feeds.pendingTx }
+
+// RemovedTxFeed publishes a tx, and the reason, whenever it leaves
+// the pending pool other than by being dequeued for inclusion in a
+// block the caller is proposing.
+func (c *Chain) RemovedTxFeed() *event.Feed[RemovedTx] { return &c.feeds.removedTx }
+
+// WitnessFeed publishes a WitnessEvent for every block this full node
+// commits, provided a WitnessGenerator has been installed via
+// SetWitnessGenerator. Light peers subscribe to this to sync headers
+// without downloading full blocks.
+func (c *Chain) WitnessFeed() *event.Feed[WitnessEvent] { return &c.feeds.witness }
+
+// SubscribePendingTxs is a convenience wrapper around PendingTxFeed
+// that only forwards txs matching filter, so an RPC layer can push
+// notifications for, say, a single asset ID without polling. The
+// forwarding goroutine it starts exits once sub is unsubscribed or
+// dropped for falling behind.
+func (c *Chain) SubscribePendingTxs(filter TxFilter, ch chan<- *bc.Tx) event.Subscription {
+	raw := make(chan *bc.Tx, cap(ch))
+	sub := c.feeds.pendingTx.Subscribe(raw)
+	go func() {
+		for {
+			select {
+			case tx := <-raw:
+				if filter == nil || filter(tx) {
+					select {
+					case ch <- tx:
+					default:
+					}
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return sub
+}
@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"chain/protocol/bc"
+)
+
+// TestSubscribePendingTxsStopsOnUnsubscribe guards against the
+// forwarding goroutine SubscribePendingTxs starts leaking forever:
+// once its subscription is unsubscribed, the goroutine must exit
+// instead of blocking on a raw channel nothing closes.
+func TestSubscribePendingTxsStopsOnUnsubscribe(t *testing.T) {
+	c := &Chain{}
+
+	before := runtime.NumGoroutine()
+
+	ch := make(chan *bc.Tx, 1)
+	sub := c.SubscribePendingTxs(nil, ch)
+	c.feeds.pendingTx.Send(&bc.Tx{Hash: bc.Hash{1}})
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("expected the subscribed tx to be forwarded")
+	}
+
+	sub.Unsubscribe()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutine count = %d, want <= %d after unsubscribe", runtime.NumGoroutine(), before)
+		}
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,272 @@
+package protocol
+
+import (
+	"context"
+
+	"chain/errors"
+	"chain/log"
+	"chain/protocol/bc"
+	"chain/protocol/state"
+)
+
+// ForkChoiceFunc selects the canonical head from a set of head
+// candidates: every known block with no recorded children. The
+// current canonical head comes first while it is still one of them;
+// once the new block extends it, it has a child and is not a
+// candidate. It's consulted once per CommitBlock call, even when the
+// new block simply extends the current tip.
+type ForkChoiceFunc func(candidates []*bc.Block) *bc.Block
+
+// ReorgCallback is invoked after the fork-choice rule switches the
+// canonical head to a block that isn't a descendant of the previous
+// head. reverted lists the abandoned blocks in tip-to-ancestor order;
+// applied lists the newly canonical blocks in ancestor-to-tip order.
+type ReorgCallback func(ctx context.Context, reverted, applied []*bc.Block) error
+
+// longestChain is the default fork-choice rule. It prefers the
+// candidate at the greatest height, breaking ties in favor of
+// whichever block is already canonical.
+func longestChain(candidates []*bc.Block) *bc.Block {
+	var best *bc.Block
+	for _, c := range candidates {
+		if best == nil || c.Height > best.Height {
+			best = c
+		}
+	}
+	return best
+}
+
+// CommitBlock stores block and its resulting state snapshot, then
+// re-evaluates the fork-choice rule over every known head candidate.
+// It is the single entry point through which new blocks -- whether
+// proposed locally or received from a peer -- become part of the
+// chain's bookkeeping, so it's safe to call for blocks that extend a
+// side branch rather than the canonical tip.
+func (c *Chain) CommitBlock(ctx context.Context, block *bc.Block, snapshot *state.Snapshot) error {
+	err := c.store.SaveBlock(ctx, block)
+	if err != nil {
+		return errors.Wrap(err, "saving block")
+	}
+
+	hash := block.Hash()
+	curHead, curSnapshot := c.State()
+
+	c.forkMu.Lock()
+	c.snapshots[hash] = snapshot
+	c.heads[hash] = block
+	delete(c.heads, block.PreviousBlockHash)
+	c.children[block.PreviousBlockHash] = append(c.children[block.PreviousBlockHash], hash)
+
+	// curHead goes first so that a fork-choice tie (e.g. two
+	// same-height candidates) keeps the chain on its current branch
+	// regardless of map iteration order.
+	candidates := make([]*bc.Block, 0, len(c.heads))
+	if curHead != nil {
+		if h, ok := c.heads[curHead.Hash()]; ok {
+			candidates = append(candidates, h)
+		}
+	}
+	for hash, h := range c.heads {
+		if curHead != nil && hash == curHead.Hash() {
+			continue
+		}
+		candidates = append(candidates, h)
+	}
+	newHead := c.forkChoice(candidates)
+	var newHeadSnapshot *state.Snapshot
+	if newHead != nil {
+		newHeadSnapshot = c.snapshots[newHead.Hash()]
+	}
+	c.forkMu.Unlock()
+
+	if newHead == nil {
+		return errors.New("fork choice returned no head")
+	}
+	if newHeadSnapshot == nil {
+		return errors.New("missing snapshot for fork-choice head")
+	}
+	if curHead != nil && newHead.Hash() == curHead.Hash() {
+		c.setState(curHead, newHeadSnapshot)
+		return nil
+	}
+	if curHead != nil && newHead.PreviousBlockHash == curHead.Hash() {
+		// newHead simply extends the current tip; no reorg needed.
+		c.setState(newHead, newHeadSnapshot)
+		c.feeds.newHead.Send(newHead)
+		c.resetPending(ctx, newHeadSnapshot, newHead.Transactions)
+		c.notifyCommittedTxs(ctx, newHead)
+		c.emitWitness(ctx, newHead, curSnapshot, newHeadSnapshot)
+		return c.runBlockCallbacks(ctx, newHead)
+	}
+
+	return c.reorg(ctx, curHead, newHead)
+}
+
+// runBlockCallbacks invokes every callback registered via
+// AddBlockCallback for the newly canonical block.
+func (c *Chain) runBlockCallbacks(ctx context.Context, block *bc.Block) error {
+	for _, f := range c.blockCallbacks {
+		err := f(ctx, block)
+		if err != nil {
+			return errors.Wrap(err, "running block callback")
+		}
+	}
+	return nil
+}
+
+// reorg switches the canonical head from curHead to newHead, walking
+// back to their common ancestor to collect the reverted and applied
+// branches. In-memory state is set directly to the snapshot
+// CommitBlock stored for newHead; no blocks are replayed.
+func (c *Chain) reorg(ctx context.Context, curHead, newHead *bc.Block) error {
+	reverted, applied, ancestor, err := c.branchPoint(ctx, curHead, newHead)
+	if err != nil {
+		return errors.Wrap(err, "finding common ancestor")
+	}
+
+	c.forkMu.Lock()
+	newSnapshot, ok := c.snapshots[newHead.Hash()]
+	c.forkMu.Unlock()
+	if !ok {
+		return errors.New("missing snapshot for new head")
+	}
+
+	c.setState(newHead, newSnapshot)
+	c.feeds.newHead.Send(newHead)
+	c.resetPending(ctx, newSnapshot, committedTxs(applied))
+	c.emitWitnesses(ctx, applied, ancestor)
+	for _, b := range applied {
+		c.notifyCommittedTxs(ctx, b)
+		if err := c.runBlockCallbacks(ctx, b); err != nil {
+			return err
+		}
+	}
+
+	if curHead == nil {
+		// This is the very first block the chain has ever seen;
+		// there's no prior head to have reorged away from.
+		return nil
+	}
+
+	log.Printf(ctx, "reorg: reverting %d block(s) back to %s, applying %d block(s) up to %s",
+		len(reverted), ancestor, len(applied), newHead.Hash())
+
+	c.feeds.reorg.Send(ReorgEvent{Reverted: reverted, Applied: applied})
+
+	for _, f := range c.reorgCallbacks {
+		err := f(ctx, reverted, applied)
+		if err != nil {
+			return errors.Wrap(err, "running reorg callback")
+		}
+	}
+	return nil
+}
+
+// branchPoint walks curHead and newHead back to their common
+// ancestor, returning the abandoned blocks (tip-to-ancestor order),
+// the newly canonical blocks (ancestor-to-tip order), and the
+// ancestor's hash itself.
+func (c *Chain) branchPoint(ctx context.Context, curHead, newHead *bc.Block) (reverted, applied []*bc.Block, ancestor bc.Hash, err error) {
+	a, b := curHead, newHead
+	var appliedRev []*bc.Block
+
+	// Walk the deeper branch back until both are at the same height
+	// (or we run off the start of a branch, i.e. curHead is nil).
+	for a != nil && b != nil && a.Height > b.Height {
+		reverted = append(reverted, a)
+		a, err = c.store.GetBlockByHash(ctx, a.PreviousBlockHash)
+		if err != nil {
+			return nil, nil, ancestor, errors.Wrap(err, "walking back to common ancestor")
+		}
+	}
+	for a != nil && b != nil && b.Height > a.Height {
+		appliedRev = append(appliedRev, b)
+		b, err = c.store.GetBlockByHash(ctx, b.PreviousBlockHash)
+		if err != nil {
+			return nil, nil, ancestor, errors.Wrap(err, "walking back to common ancestor")
+		}
+	}
+
+	// Walk both branches back in lockstep until they converge on a
+	// shared ancestor, or both run out (curHead was nil, i.e. this is
+	// the very first block the chain has ever seen).
+	for {
+		if a == nil && b == nil {
+			return reverted, reverseBlocks(appliedRev), bc.Hash{}, nil
+		}
+		if a != nil && b != nil && a.Hash() == b.Hash() {
+			return reverted, reverseBlocks(appliedRev), a.Hash(), nil
+		}
+		if a != nil {
+			reverted = append(reverted, a)
+			a, err = c.store.GetBlockByHash(ctx, a.PreviousBlockHash)
+			if err != nil {
+				return nil, nil, ancestor, errors.Wrap(err, "walking back to common ancestor")
+			}
+		}
+		if b != nil {
+			appliedRev = append(appliedRev, b)
+			b, err = c.store.GetBlockByHash(ctx, b.PreviousBlockHash)
+			if err != nil {
+				return nil, nil, ancestor, errors.Wrap(err, "walking back to common ancestor")
+			}
+		}
+	}
+}
+
+// committedTxs flattens every transaction across blocks, in block
+// order.
+func committedTxs(blocks []*bc.Block) []*bc.Tx {
+	var txs []*bc.Tx
+	for _, b := range blocks {
+		txs = append(txs, b.Transactions...)
+	}
+	return txs
+}
+
+func reverseBlocks(blocks []*bc.Block) []*bc.Block {
+	out := make([]*bc.Block, len(blocks))
+	for i, b := range blocks {
+		out[len(blocks)-1-i] = b
+	}
+	return out
+}
+
+// FinalizeBlock marks height (and everything before it) as settled,
+// preventing any future reorg below that point. It prunes every
+// branch that forked off below height from both the in-memory
+// bookkeeping and the Store.
+func (c *Chain) FinalizeBlock(ctx context.Context, height uint64) error {
+	err := c.store.FinalizeBlock(ctx, height)
+	if err != nil {
+		return errors.Wrap(err, "finalizing block")
+	}
+
+	finalized, err := c.store.GetBlock(ctx, height)
+	if err != nil {
+		return errors.Wrap(err, "loading finalized block")
+	}
+
+	c.forkMu.Lock()
+	c.finalizedHeight = height
+	c.finalizedHash = finalized.Hash()
+	for hash := range c.snapshots {
+		if hash == c.finalizedHash {
+			continue
+		}
+		blk, err := c.store.GetBlockByHash(ctx, hash)
+		if err != nil {
+			log.Error(ctx, err, "at", "loading block to prune stale snapshot")
+			continue
+		}
+		if blk == nil || blk.Height <= height {
+			delete(c.snapshots, hash)
+			delete(c.heads, hash)
+		}
+	}
+	c.forkMu.Unlock()
+
+	c.feeds.newFinal.Send(finalized)
+
+	return c.store.PruneBranches(ctx, height)
+}
@@ -0,0 +1,343 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"chain/protocol/bc"
+	"chain/protocol/state"
+)
+
+// memStore is a minimal in-memory Store used to exercise fork
+// handling without a real database.
+type memStore struct {
+	height    uint64
+	finalized uint64
+	blocks    map[bc.Hash]*bc.Block
+	byHeight  map[uint64][]*bc.Block
+	pending   *bc.Block
+	snapshots map[uint64]*state.Snapshot
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		blocks:    make(map[bc.Hash]*bc.Block),
+		byHeight:  make(map[uint64][]*bc.Block),
+		snapshots: make(map[uint64]*state.Snapshot),
+	}
+}
+
+func (s *memStore) Height(context.Context) (uint64, error) { return s.height, nil }
+
+func (s *memStore) GetBlock(ctx context.Context, height uint64) (*bc.Block, error) {
+	blocks := s.byHeight[height]
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+	return blocks[0], nil
+}
+
+func (s *memStore) GetBlockByHash(ctx context.Context, hash bc.Hash) (*bc.Block, error) {
+	return s.blocks[hash], nil
+}
+
+func (s *memStore) Heads(ctx context.Context) ([]*bc.Block, error) {
+	children := make(map[bc.Hash]bool)
+	for _, b := range s.blocks {
+		children[b.PreviousBlockHash] = true
+	}
+	var heads []*bc.Block
+	for hash, b := range s.blocks {
+		if !children[hash] {
+			heads = append(heads, b)
+		}
+	}
+	return heads, nil
+}
+
+func (s *memStore) LatestSnapshot(context.Context) (*state.Snapshot, uint64, error) {
+	return nil, s.height, nil
+}
+
+func (s *memStore) SaveBlock(ctx context.Context, b *bc.Block) error {
+	hash := b.Hash()
+	s.blocks[hash] = b
+	s.byHeight[b.Height] = append(s.byHeight[b.Height], b)
+	if b.Height > s.height {
+		s.height = b.Height
+	}
+	return nil
+}
+
+func (s *memStore) FinalizeBlock(ctx context.Context, height uint64) error {
+	s.finalized = height
+	return nil
+}
+
+func (s *memStore) SaveSnapshot(ctx context.Context, height uint64, snap *state.Snapshot) error {
+	s.snapshots[height] = snap
+	return nil
+}
+
+func (s *memStore) GetSnapshot(ctx context.Context, height uint64) (*state.Snapshot, error) {
+	return s.snapshots[height], nil
+}
+
+func (s *memStore) ListSnapshotHeights(ctx context.Context) ([]uint64, error) {
+	heights := make([]uint64, 0, len(s.snapshots))
+	for h := range s.snapshots {
+		heights = append(heights, h)
+	}
+	return heights, nil
+}
+
+func (s *memStore) DeleteSnapshot(ctx context.Context, height uint64) error {
+	delete(s.snapshots, height)
+	return nil
+}
+
+func (s *memStore) PruneBranches(ctx context.Context, height uint64) error {
+	for h, blocks := range s.byHeight {
+		if h > height {
+			continue
+		}
+		kept := blocks[:0]
+		for _, b := range blocks {
+			if h < height || s.finalized == 0 {
+				continue
+			}
+			kept = append(kept, b)
+		}
+		s.byHeight[h] = kept
+	}
+	return nil
+}
+
+func (s *memStore) SavePendingBlock(ctx context.Context, b *bc.Block) error {
+	s.pending = b
+	return nil
+}
+
+func (s *memStore) GetPendingBlock(ctx context.Context) (*bc.Block, error) {
+	return s.pending, nil
+}
+
+func (s *memStore) DiscardPendingBlock(ctx context.Context) error {
+	s.pending = nil
+	return nil
+}
+
+func testBlock(height uint64, prev bc.Hash, seed byte) *bc.Block {
+	b := &bc.Block{
+		BlockHeader: bc.BlockHeader{
+			Height:            height,
+			PreviousBlockHash: prev,
+		},
+	}
+	b.BlockHeader.Nonce = uint64(seed)
+	return b
+}
+
+// TestChainReorg stages two competing branches that fork off the
+// genesis block and confirms that committing a longer side branch
+// switches the canonical head, fires the reorg callback with the
+// correct reverted/applied blocks, and rolls back the in-memory
+// snapshot.
+func TestChainReorg(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+	store.height = 1
+
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesisSnap := &state.Snapshot{}
+	if err := c.CommitBlock(ctx, genesis, genesisSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	var reorgs []struct{ reverted, applied []*bc.Block }
+	c.AddReorgCallback(func(ctx context.Context, reverted, applied []*bc.Block) error {
+		reorgs = append(reorgs, struct{ reverted, applied []*bc.Block }{reverted, applied})
+		return nil
+	})
+
+	// Branch A: a single block extending genesis.
+	blockA := testBlock(2, genesis.Hash(), 0xA)
+	snapA := &state.Snapshot{}
+	if err := c.CommitBlock(ctx, blockA, snapA); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := c.State(); head.Hash() != blockA.Hash() {
+		t.Fatalf("expected head = blockA after first branch, got %v", head.Hash())
+	}
+
+	// Branch B: two blocks extending genesis, longer than branch A.
+	blockB1 := testBlock(2, genesis.Hash(), 0xB1)
+	snapB1 := &state.Snapshot{}
+	if err := c.CommitBlock(ctx, blockB1, snapB1); err != nil {
+		t.Fatal(err)
+	}
+	// Still on branch A: same height, ties favor the current head. The
+	// snapshot must stay blockA's post-state too, not blockB1's -- the
+	// tie commit shouldn't overwrite canonical state with a losing
+	// side branch's.
+	if head, snap := c.State(); head.Hash() != blockA.Hash() {
+		t.Fatalf("expected head = blockA after tie, got %v", head.Hash())
+	} else if snap != snapA {
+		t.Fatalf("expected in-memory snapshot to remain blockA's after a losing tie commit")
+	}
+
+	blockB2 := testBlock(3, blockB1.Hash(), 0xB2)
+	snapB2 := &state.Snapshot{}
+	if err := c.CommitBlock(ctx, blockB2, snapB2); err != nil {
+		t.Fatal(err)
+	}
+
+	head, snap := c.State()
+	if head.Hash() != blockB2.Hash() {
+		t.Fatalf("expected head = blockB2 after reorg, got %v", head.Hash())
+	}
+	if snap != snapB2 {
+		t.Fatalf("expected in-memory snapshot to roll forward to blockB2's snapshot")
+	}
+
+	if len(reorgs) != 1 {
+		t.Fatalf("expected exactly one reorg callback, got %d", len(reorgs))
+	}
+	wantReverted := []*bc.Block{blockA}
+	wantApplied := []*bc.Block{blockB1, blockB2}
+	if !reflect.DeepEqual(reorgs[0].reverted, wantReverted) {
+		t.Errorf("reverted blocks = %v, want %v", reorgs[0].reverted, wantReverted)
+	}
+	if !reflect.DeepEqual(reorgs[0].applied, wantApplied) {
+		t.Errorf("applied blocks = %v, want %v", reorgs[0].applied, wantApplied)
+	}
+}
+
+// TestChainBlockCallback confirms AddBlockCallback fires for every
+// block that becomes canonical, whether by simple extension or by
+// reorg -- including every intermediate block a multi-block reorg
+// applies, not just its new tip -- and not for a losing side-branch
+// commit.
+func TestChainBlockCallback(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+	store.height = 1
+
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []*bc.Block
+	c.AddBlockCallback(func(ctx context.Context, block *bc.Block) error {
+		seen = append(seen, block)
+		return nil
+	})
+
+	if err := c.CommitBlock(ctx, genesis, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	blockA := testBlock(2, genesis.Hash(), 0xA)
+	if err := c.CommitBlock(ctx, blockA, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A losing side-branch commit at the same height must not fire
+	// the callback.
+	blockB1 := testBlock(2, genesis.Hash(), 0xB1)
+	if err := c.CommitBlock(ctx, blockB1, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	blockB2 := testBlock(3, blockB1.Hash(), 0xB2)
+	if err := c.CommitBlock(ctx, blockB2, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*bc.Block{genesis, blockA, blockB1, blockB2}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("callback saw blocks = %v, want %v", seen, want)
+	}
+}
+
+// TestChainReorgEmitsWitnessPerBlock confirms a reorg publishes a
+// WitnessEvent for every block it applies, ancestor to tip, not just
+// the new head -- so a light peer following WitnessFeed can verify
+// the whole reorg rather than jumping straight to the new tip.
+func TestChainReorgEmitsWitnessPerBlock(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+	store.height = 1
+
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetWitnessGenerator(func(ctx context.Context, block *bc.Block, prevSnapshot, newSnapshot *state.Snapshot) (WitnessData, error) {
+		return WitnessData{PreviousAssetsRoot: bc.Hash{byte(block.BlockHeader.Nonce)}}, nil
+	})
+
+	ch := make(chan WitnessEvent, 4)
+	sub := c.WitnessFeed().Subscribe(ch)
+	defer sub.Unsubscribe()
+
+	if err := c.CommitBlock(ctx, genesis, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+	<-ch // genesis's own witness; not under test here
+
+	blockA := testBlock(2, genesis.Hash(), 0xA)
+	if err := c.CommitBlock(ctx, blockA, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+	<-ch // blockA's witness from the simple-extend path
+
+	blockB1 := testBlock(2, genesis.Hash(), 0xB1)
+	if err := c.CommitBlock(ctx, blockB1, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	blockB2 := testBlock(3, blockB1.Hash(), 0xB2)
+	if err := c.CommitBlock(ctx, blockB2, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*bc.Block
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-ch:
+			got = append(got, &bc.Block{BlockHeader: *ev.Header})
+		case <-time.After(time.Second):
+			t.Fatalf("expected 2 witness events from the reorg, got %d", len(got))
+		}
+	}
+
+	wantHashes := []bc.Hash{blockB1.Hash(), blockB2.Hash()}
+	for i, b := range got {
+		if b.Hash() != wantHashes[i] {
+			t.Errorf("witness %d header hash = %v, want %v", i, b.Hash(), wantHashes[i])
+		}
+	}
+}
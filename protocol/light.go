@@ -0,0 +1,242 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"chain/errors"
+	"chain/log"
+	"chain/protocol/bc"
+	"chain/protocol/state"
+)
+
+// MerkleProof is a compact inclusion proof against a single Merkle
+// root, sufficient to prove that a single leaf is (or isn't) present
+// without holding the whole tree.
+type MerkleProof struct {
+	Key   []byte
+	Value []byte
+	Path  [][]byte // sibling hashes from leaf to root
+}
+
+// RootTransitionProof proves that a single leaf changed from OldValue
+// to NewValue as part of a root moving from one header to the next.
+// Path is the same sibling-hash list in both directions: a leaf's
+// position in the tree depends only on its key, not its value.
+type RootTransitionProof struct {
+	Key      []byte
+	OldValue []byte
+	NewValue []byte
+	Path     [][]byte // sibling hashes from leaf to root
+}
+
+// WitnessData carries everything a light client needs to verify that
+// a header's assets-root and issuances-root are a valid transition
+// from the previous header's roots, without executing the block's
+// transactions or holding the full state tree. A full node computes
+// WitnessData as part of committing a block and can hand it to any
+// light peer syncing from it.
+type WitnessData struct {
+	PreviousAssetsRoot    bc.Hash
+	PreviousIssuancesRoot bc.Hash
+	AssetsRootProofs      []RootTransitionProof // one proof per output touched this block
+	IssuancesRootProofs   []RootTransitionProof // one proof per issuance touched this block
+}
+
+// HeaderStore is the storage a light client needs: enough to track
+// headers without holding blocks or state. It's a strict subset of
+// Store, so a full node's Store can double as a HeaderStore for any
+// light peers it serves.
+type HeaderStore interface {
+	Height(context.Context) (uint64, error)
+	SaveHeader(context.Context, *bc.BlockHeader) error
+	GetHeader(context.Context, uint64) (*bc.BlockHeader, error)
+}
+
+// WitnessGenerator computes the WitnessData proving that block's
+// header roots are a valid transition from prevSnapshot to
+// newSnapshot. It's the one piece of witness generation that depends
+// on the concrete Merkle tree implementation backing state.Snapshot,
+// so it's supplied by the caller rather than hard-coded here.
+type WitnessGenerator func(ctx context.Context, block *bc.Block, prevSnapshot, newSnapshot *state.Snapshot) (WitnessData, error)
+
+// NewLightChain returns a Chain running in header-only light-sync
+// mode: it follows the chain by verifying headers against
+// WitnessData via ValidateHeader, without executing transactions or
+// maintaining a UTXO tree. A light chain has no Store or Pool: State
+// and PendingBlock return nil values on it, and methods that use the
+// Store or Pool (AddTx, CommitBlock, FinalizeBlock, ...) are not
+// supported on it; they do not check for a light chain and do not
+// return ErrLightChain.
+func NewLightChain(ctx context.Context, initialBlockHash bc.Hash, headers HeaderStore) (*Chain, error) {
+	c := &Chain{
+		InitialBlockHash: initialBlockHash,
+		headerStore:      headers,
+	}
+	c.state.cond.L = new(sync.Mutex)
+
+	var err error
+	c.state.height, err = headers.Height(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "looking up header height")
+	}
+	return c, nil
+}
+
+// ErrLightChain is returned by the light-chain methods ValidateHeader
+// and VerifyOutputInclusion when called on a Chain that was not built
+// with NewLightChain.
+var ErrLightChain = errors.New("not available on a header-only light chain")
+
+// SetWitnessGenerator installs f as the function full nodes use to
+// produce WitnessData when committing a block, so light peers can
+// sync from them. It has no effect on a light chain.
+func (c *Chain) SetWitnessGenerator(f WitnessGenerator) {
+	c.forkMu.Lock()
+	defer c.forkMu.Unlock()
+	c.witnessGen = f
+}
+
+// ValidateHeader checks that header is a valid successor to the
+// light chain's current tip: its PreviousBlockHash must match the
+// previous header, and witness must prove that header's AssetsRoot
+// and IssuancesRoot are a valid transition from that header's roots.
+// On success it advances the light chain's tip to header.
+func (c *Chain) ValidateHeader(ctx context.Context, header *bc.BlockHeader, witness WitnessData) error {
+	if c.headerStore == nil {
+		return ErrLightChain
+	}
+
+	if header.Height > 1 {
+		prev, err := c.headerStore.GetHeader(ctx, header.Height-1)
+		if err != nil {
+			return errors.Wrap(err, "loading previous header")
+		}
+		if prev == nil {
+			return errors.New("missing previous header")
+		}
+		if header.PreviousBlockHash != prev.Hash() {
+			return errors.New("header does not extend the light chain's tip")
+		}
+		if witness.PreviousAssetsRoot != prev.AssetsRoot || witness.PreviousIssuancesRoot != prev.IssuancesRoot {
+			return errors.New("witness data doesn't match the previous header's roots")
+		}
+	}
+
+	err := verifyRootTransition(witness.PreviousAssetsRoot, header.AssetsRoot, witness.AssetsRootProofs)
+	if err != nil {
+		return errors.Wrap(err, "verifying assets-root transition")
+	}
+	err = verifyRootTransition(witness.PreviousIssuancesRoot, header.IssuancesRoot, witness.IssuancesRootProofs)
+	if err != nil {
+		return errors.Wrap(err, "verifying issuances-root transition")
+	}
+
+	err = c.headerStore.SaveHeader(ctx, header)
+	if err != nil {
+		return errors.Wrap(err, "saving header")
+	}
+	c.setHeight(header.Height)
+	return nil
+}
+
+// verifyRootTransition checks that proofs fully account for oldRoot
+// becoming newRoot: every proof must show its leaf's OldValue
+// included under oldRoot and its NewValue included under newRoot,
+// using the same sibling path for both. A root that actually changed
+// must be backed by at least one proof -- an empty proofs slice is
+// only valid when oldRoot already equals newRoot.
+func verifyRootTransition(oldRoot bc.Hash, newRoot bc.Hash, proofs []RootTransitionProof) error {
+	if len(proofs) == 0 {
+		if oldRoot != newRoot {
+			return errors.New("root changed but no merkle proofs were supplied")
+		}
+		return nil
+	}
+	for _, p := range proofs {
+		if !patriciaVerify(oldRoot, p.Key, p.OldValue, p.Path) {
+			return errors.New("invalid merkle proof against previous root")
+		}
+		if !patriciaVerify(newRoot, p.Key, p.NewValue, p.Path) {
+			return errors.New("invalid merkle proof against new root")
+		}
+	}
+	return nil
+}
+
+// patriciaVerify is the hook into the state package's Merkle tree
+// implementation. It's a var, not a hard call, so tests can swap in a
+// fake without needing a real patricia tree.
+var patriciaVerify = func(root bc.Hash, key, value []byte, path [][]byte) bool {
+	return state.VerifyMerkleProof(root, key, value, path)
+}
+
+// emitWitness generates the WitnessData for block, if a
+// WitnessGenerator is installed, and publishes it on the WitnessFeed
+// so any light peers following this full node can verify block's
+// header without downloading the block itself.
+func (c *Chain) emitWitness(ctx context.Context, block *bc.Block, prevSnapshot, newSnapshot *state.Snapshot) {
+	c.forkMu.Lock()
+	gen := c.witnessGen
+	c.forkMu.Unlock()
+	if gen == nil {
+		return
+	}
+
+	witness, err := gen(ctx, block, prevSnapshot, newSnapshot)
+	if err != nil {
+		log.Error(ctx, err, "at", "generating witness data")
+		return
+	}
+	c.feeds.witness.Send(WitnessEvent{Header: &block.BlockHeader, Witness: witness})
+}
+
+// emitWitnesses generates and publishes WitnessData for every block
+// in applied, ancestor to tip, so a light peer following WitnessFeed
+// can verify a reorg block by block instead of jumping straight to
+// the new tip. ancestor is the common-ancestor hash applied[0]
+// extends.
+func (c *Chain) emitWitnesses(ctx context.Context, applied []*bc.Block, ancestor bc.Hash) {
+	prevHash := ancestor
+	for _, b := range applied {
+		c.forkMu.Lock()
+		prevSnapshot := c.snapshots[prevHash]
+		newSnapshot := c.snapshots[b.Hash()]
+		c.forkMu.Unlock()
+		c.emitWitness(ctx, b, prevSnapshot, newSnapshot)
+		prevHash = b.Hash()
+	}
+}
+
+// VerifyOutputInclusion reports whether outpoint is present in the
+// UTXO set committed to by the chain's current header, given a
+// Merkle inclusion proof for it. It lets a light node answer queries
+// about a specific UTXO without holding the whole state tree.
+func (c *Chain) VerifyOutputInclusion(ctx context.Context, outpoint bc.Outpoint, proof MerkleProof) (bool, error) {
+	if c.headerStore == nil {
+		return false, ErrLightChain
+	}
+	if string(proof.Key) != string(outpointKey(outpoint)) {
+		return false, nil
+	}
+	header, err := c.headerStore.GetHeader(ctx, c.Height())
+	if err != nil {
+		return false, errors.Wrap(err, "loading current header")
+	}
+	if header == nil {
+		return false, errors.New("no header available yet")
+	}
+	return patriciaVerify(header.AssetsRoot, proof.Key, proof.Value, proof.Path), nil
+}
+
+// outpointKey derives the UTXO-tree key for outpoint: its
+// transaction hash followed by its output index, big-endian. It's
+// the binding between a caller's outpoint and the leaf a MerkleProof
+// must be proving membership for.
+func outpointKey(outpoint bc.Outpoint) []byte {
+	key := make([]byte, len(outpoint.Hash)+4)
+	n := copy(key, outpoint.Hash[:])
+	binary.BigEndian.PutUint32(key[n:], outpoint.Index)
+	return key
+}
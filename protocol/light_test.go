@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+
+	"chain/protocol/bc"
+)
+
+type memHeaderStore struct {
+	height  uint64
+	headers map[uint64]*bc.BlockHeader
+}
+
+func newMemHeaderStore() *memHeaderStore {
+	return &memHeaderStore{headers: make(map[uint64]*bc.BlockHeader)}
+}
+
+func (s *memHeaderStore) Height(context.Context) (uint64, error) { return s.height, nil }
+
+func (s *memHeaderStore) SaveHeader(ctx context.Context, h *bc.BlockHeader) error {
+	s.headers[h.Height] = h
+	if h.Height > s.height {
+		s.height = h.Height
+	}
+	return nil
+}
+
+func (s *memHeaderStore) GetHeader(ctx context.Context, height uint64) (*bc.BlockHeader, error) {
+	return s.headers[height], nil
+}
+
+func TestLightChainValidateHeader(t *testing.T) {
+	// The proofs below are made up, so swap in a verifier that
+	// accepts any proof; the real Merkle check isn't under test.
+	origVerify := patriciaVerify
+	patriciaVerify = func(bc.Hash, []byte, []byte, [][]byte) bool { return true }
+	defer func() { patriciaVerify = origVerify }()
+
+	ctx := context.Background()
+	store := newMemHeaderStore()
+
+	genesis := &bc.BlockHeader{Height: 1}
+	if err := store.SaveHeader(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewLightChain(ctx, genesis.Hash(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := &bc.BlockHeader{
+		Height:            2,
+		PreviousBlockHash: genesis.Hash(),
+		AssetsRoot:        bc.Hash{1},
+		IssuancesRoot:     bc.Hash{2},
+	}
+	witness := WitnessData{
+		PreviousAssetsRoot:    genesis.AssetsRoot,
+		PreviousIssuancesRoot: genesis.IssuancesRoot,
+		AssetsRootProofs: []RootTransitionProof{
+			{Key: []byte("out1"), OldValue: nil, NewValue: []byte("spent")},
+		},
+		IssuancesRootProofs: []RootTransitionProof{
+			{Key: []byte("iss1"), OldValue: nil, NewValue: []byte("issued")},
+		},
+	}
+
+	if err := c.ValidateHeader(ctx, next, witness); err != nil {
+		t.Fatal(err)
+	}
+	if c.Height() != 2 {
+		t.Errorf("height = %d, want 2", c.Height())
+	}
+
+	// A header that doesn't extend the tip must be rejected.
+	bogus := &bc.BlockHeader{Height: 3, PreviousBlockHash: bc.Hash{0xff}}
+	if err := c.ValidateHeader(ctx, bogus, WitnessData{}); err == nil {
+		t.Fatal("expected an error for a header that doesn't extend the tip")
+	}
+}
+
+func TestLightChainValidateHeaderRejectsUnprovenRootChange(t *testing.T) {
+	ctx := context.Background()
+	store := newMemHeaderStore()
+
+	genesis := &bc.BlockHeader{Height: 1}
+	if err := store.SaveHeader(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewLightChain(ctx, genesis.Hash(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// next claims a new AssetsRoot but the witness carries no proof
+	// accounting for the change; it must be rejected rather than
+	// trivially accepted.
+	next := &bc.BlockHeader{
+		Height:            2,
+		PreviousBlockHash: genesis.Hash(),
+		AssetsRoot:        bc.Hash{1},
+	}
+	witness := WitnessData{
+		PreviousAssetsRoot:    genesis.AssetsRoot,
+		PreviousIssuancesRoot: genesis.IssuancesRoot,
+	}
+	if err := c.ValidateHeader(ctx, next, witness); err == nil {
+		t.Fatal("expected an error for a root change with no accompanying proof")
+	}
+}
+
+func TestLightChainVerifyOutputInclusion(t *testing.T) {
+	// The proof below is made up, so swap in a verifier that accepts
+	// any proof; only the outpoint-to-key binding is under test.
+	origVerify := patriciaVerify
+	patriciaVerify = func(bc.Hash, []byte, []byte, [][]byte) bool { return true }
+	defer func() { patriciaVerify = origVerify }()
+
+	ctx := context.Background()
+	store := newMemHeaderStore()
+	genesis := &bc.BlockHeader{Height: 1, AssetsRoot: bc.Hash{9}}
+	if err := store.SaveHeader(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewLightChain(ctx, genesis.Hash(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outpoint := bc.Outpoint{Hash: bc.Hash{0xaa}, Index: 3}
+	proof := MerkleProof{Key: outpointKey(outpoint), Value: []byte("utxo")}
+
+	included, err := c.VerifyOutputInclusion(ctx, outpoint, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !included {
+		t.Error("expected output to verify as included")
+	}
+
+	// A proof for a different outpoint must not verify.
+	other := bc.Outpoint{Hash: bc.Hash{0xbb}, Index: 1}
+	included, err = c.VerifyOutputInclusion(ctx, other, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if included {
+		t.Error("expected a proof bound to a different outpoint to fail")
+	}
+}
+
+func TestFullChainRejectsLightOnlyCalls(t *testing.T) {
+	ctx := context.Background()
+	full := &Chain{}
+	if err := full.ValidateHeader(ctx, &bc.BlockHeader{}, WitnessData{}); err != ErrLightChain {
+		t.Errorf("expected ErrLightChain, got %v", err)
+	}
+	if _, err := full.VerifyOutputInclusion(ctx, bc.Outpoint{}, MerkleProof{}); err != ErrLightChain {
+		t.Errorf("expected ErrLightChain, got %v", err)
+	}
+}
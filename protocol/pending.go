@@ -0,0 +1,203 @@
+package protocol
+
+import (
+	"context"
+
+	"chain/errors"
+	"chain/log"
+	"chain/protocol/bc"
+	"chain/protocol/state"
+	"chain/protocol/validation"
+)
+
+// PendingBlock returns the block currently being assembled by a local
+// proposer, together with its speculative post-state -- the result
+// of applying every tx in the block so far to the last committed
+// snapshot. It returns nil, nil if no block is being assembled yet.
+//
+// The returned snapshot is never mutated in place; each call to AddTx
+// produces a new one, so a caller that holds on to a previously
+// returned snapshot is holding a consistent, read-only view of that
+// point in time.
+func (c *Chain) PendingBlock() (*bc.Block, *state.Snapshot) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	return c.pendingBlock, c.pendingSnapshot
+}
+
+// PendingTxs returns the transactions collected into the block
+// currently being assembled, in the order they were added.
+func (c *Chain) PendingTxs() []*bc.Tx {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	txs := make([]*bc.Tx, len(c.pendingTxs))
+	copy(txs, c.pendingTxs)
+	return txs
+}
+
+// DiscardPending abandons the block currently being assembled. The
+// next call to AddTx (or ensurePending) starts a fresh one from the
+// current canonical tip.
+func (c *Chain) DiscardPending(ctx context.Context) error {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	c.pendingBlock = nil
+	c.pendingSnapshot = nil
+	c.pendingTxs = nil
+	return c.store.DiscardPendingBlock(ctx)
+}
+
+// resetPending discards any in-progress pending block because the
+// canonical tip it was built on has moved; it's called after every
+// commit and reorg. A fresh pending block is started lazily, the
+// next time AddTx runs.
+//
+// committed lists the transactions that just landed in newSnapshot
+// (reported separately, via notifyCommittedTxs); every other tx that was
+// part of the discarded pending block is replayed against
+// newSnapshot, and any that no longer applies -- because a committed
+// tx already spent the same output -- is reported on RemovedTxFeed
+// with RemoveConflict.
+func (c *Chain) resetPending(ctx context.Context, newSnapshot *state.Snapshot, committed []*bc.Tx) {
+	c.pendingMu.Lock()
+	stale := c.pendingTxs
+	c.pendingBlock = nil
+	c.pendingSnapshot = nil
+	c.pendingTxs = nil
+	c.pendingMu.Unlock()
+
+	err := c.store.DiscardPendingBlock(ctx)
+	if err != nil {
+		log.Error(ctx, err, "at", "discarding stale pending block")
+	}
+
+	c.notifyConflictingTxs(ctx, stale, committed, newSnapshot)
+}
+
+// notifyConflictingTxs reports RemoveConflict for every tx in stale
+// that isn't itself in committed but no longer applies cleanly to
+// snapshot -- the losing side of a double-spend against a
+// transaction that just landed on chain instead.
+func (c *Chain) notifyConflictingTxs(ctx context.Context, stale, committed []*bc.Tx, snapshot *state.Snapshot) {
+	if len(stale) == 0 || snapshot == nil {
+		return
+	}
+
+	landed := make(map[bc.Hash]bool, len(committed))
+	for _, tx := range committed {
+		landed[tx.Hash] = true
+	}
+
+	speculative := snapshot.Copy()
+	for _, tx := range stale {
+		if landed[tx.Hash] {
+			continue
+		}
+		err := validation.ApplyTx(speculative, tx)
+		if err != nil {
+			c.feeds.removedTx.Send(RemovedTx{Tx: tx, Reason: RemoveConflict})
+		}
+	}
+}
+
+// ensurePending makes sure a pending block exists, starting one from
+// the current canonical tip if necessary. Callers must hold
+// c.pendingMu.
+func (c *Chain) ensurePending(ctx context.Context) error {
+	if c.pendingBlock != nil {
+		return nil
+	}
+	head, snapshot := c.State()
+	pendingHeight := uint64(1)
+	prevHash := c.InitialBlockHash
+	if head != nil {
+		pendingHeight = head.Height + 1
+		prevHash = head.Hash()
+	}
+	if snapshot == nil {
+		snapshot = new(state.Snapshot)
+	}
+	c.pendingBlock = &bc.Block{
+		BlockHeader: bc.BlockHeader{
+			Height:            pendingHeight,
+			PreviousBlockHash: prevHash,
+		},
+	}
+	c.pendingSnapshot = snapshot.Copy()
+	c.pendingTxs = nil
+	return nil
+}
+
+// addToPending applies tx to the in-progress pending block's
+// speculative snapshot and appends it to the pending block's
+// transaction list, persisting the updated pending block so a
+// proposer restart can resume collecting signatures for it. Failures
+// to apply are not fatal to AddTx: a tx that doesn't fit the pending
+// block's speculative state yet may still be valid once the pending
+// block is rebuilt from a later tip.
+func (c *Chain) addToPending(ctx context.Context, tx *bc.Tx) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	err := c.ensurePending(ctx)
+	if err != nil {
+		log.Error(ctx, err, "at", "starting pending block")
+		return
+	}
+
+	speculative := c.pendingSnapshot.Copy()
+	err = validation.ApplyTx(speculative, tx)
+	if err != nil {
+		// tx doesn't fit the speculative state yet (e.g. it spends
+		// an output another pending tx also spends); leave it out of
+		// this block rather than failing AddTx.
+		return
+	}
+
+	c.pendingSnapshot = speculative
+	c.pendingTxs = append(c.pendingTxs, tx)
+	c.pendingBlock.Transactions = append(c.pendingBlock.Transactions, tx)
+
+	err = c.store.SavePendingBlock(ctx, c.pendingBlock)
+	if err != nil {
+		log.Error(ctx, err, "at", "saving pending block")
+	}
+}
+
+// loadPendingBlock reloads a previously saved in-flight pending
+// block, reconstructing its speculative snapshot by replaying its
+// transactions against the last committed snapshot. It's called once
+// from NewChain so a proposer restart doesn't drop an in-flight
+// block and the signatures already collected for it.
+func (c *Chain) loadPendingBlock(ctx context.Context) error {
+	pending, err := c.store.GetPendingBlock(ctx)
+	if err != nil {
+		return errors.Wrap(err, "loading pending block")
+	}
+	if pending == nil {
+		return nil
+	}
+
+	snapshot, _, err := c.store.LatestSnapshot(ctx)
+	if err != nil {
+		return errors.Wrap(err, "loading latest snapshot")
+	}
+	if snapshot == nil {
+		snapshot = new(state.Snapshot)
+	}
+	snapshot = snapshot.Copy()
+
+	for _, tx := range pending.Transactions {
+		err := validation.ApplyTx(snapshot, tx)
+		if err != nil {
+			return errors.Wrap(err, "replaying pending tx")
+		}
+	}
+
+	c.pendingMu.Lock()
+	c.pendingBlock = pending
+	c.pendingSnapshot = snapshot
+	c.pendingTxs = append([]*bc.Tx{}, pending.Transactions...)
+	c.pendingMu.Unlock()
+	return nil
+}
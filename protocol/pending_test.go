@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+
+	"chain/protocol/bc"
+	"chain/protocol/state"
+)
+
+func TestChainPendingBlock(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	store.height = 1
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, genesis, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if block, snap := c.PendingBlock(); block != nil || snap != nil {
+		t.Fatalf("expected no pending block before any tx is added")
+	}
+
+	tx := &bc.Tx{Hash: bc.Hash{0x1}}
+	c.addToPending(ctx, tx)
+
+	block, snap := c.PendingBlock()
+	if block == nil {
+		t.Fatal("expected a pending block after addToPending")
+	}
+	if block.Height != genesis.Height+1 {
+		t.Errorf("pending block height = %d, want %d", block.Height, genesis.Height+1)
+	}
+	if block.PreviousBlockHash != genesis.Hash() {
+		t.Errorf("pending block previous hash = %v, want %v", block.PreviousBlockHash, genesis.Hash())
+	}
+	if snap == nil {
+		t.Fatal("expected a pending snapshot after addToPending")
+	}
+
+	txs := c.PendingTxs()
+	if len(txs) != 1 || txs[0].Hash != tx.Hash {
+		t.Errorf("pending txs = %v, want [%v]", txs, tx)
+	}
+
+	if err := c.DiscardPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if block, snap := c.PendingBlock(); block != nil || snap != nil {
+		t.Fatalf("expected no pending block after DiscardPending")
+	}
+	if len(c.PendingTxs()) != 0 {
+		t.Fatalf("expected no pending txs after DiscardPending")
+	}
+}
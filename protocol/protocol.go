@@ -44,6 +44,7 @@ To ingest a block, call ValidateBlock and CommitBlock.
 package protocol
 
 import (
+	"container/heap"
 	"context"
 	"sync"
 	"time"
@@ -74,14 +75,51 @@ type BlockCallback func(ctx context.Context, block *bc.Block) error
 // provides access to the state at a given point in time -- outputs
 // and issuance memory. The Chain type uses Store to load state
 // from storage and persist validated data.
+//
+// Store is expected to retain every block it's given, not just
+// those on the current canonical branch. Chain relies on this to
+// track side branches and to walk back to a common ancestor when
+// the fork-choice rule selects a new head.
 type Store interface {
 	Height(context.Context) (uint64, error)
 	GetBlock(context.Context, uint64) (*bc.Block, error)
+	GetBlockByHash(context.Context, bc.Hash) (*bc.Block, error)
+
+	// Heads returns the current set of head candidates: blocks
+	// that, as far as this Store knows, have no children yet.
+	Heads(context.Context) ([]*bc.Block, error)
+
 	LatestSnapshot(context.Context) (*state.Snapshot, uint64, error)
 
+	// SaveBlock persists block. It must be idempotent and must not
+	// assume block extends the current canonical tip; block may
+	// start or extend a side branch.
 	SaveBlock(context.Context, *bc.Block) error
 	FinalizeBlock(context.Context, uint64) error
 	SaveSnapshot(context.Context, uint64, *state.Snapshot) error
+
+	// PruneBranches discards any blocks and snapshots that are no
+	// longer reachable from the canonical chain now that height has
+	// been finalized.
+	PruneBranches(ctx context.Context, height uint64) error
+
+	// SavePendingBlock persists the block a proposer is currently
+	// assembling, so a crash doesn't lose collected signatures.
+	SavePendingBlock(ctx context.Context, block *bc.Block) error
+	// GetPendingBlock returns the previously saved pending block, or
+	// nil if there is none.
+	GetPendingBlock(ctx context.Context) (*bc.Block, error)
+	// DiscardPendingBlock removes any saved pending block.
+	DiscardPendingBlock(ctx context.Context) error
+
+	// GetSnapshot returns the snapshot retained at exactly height, or
+	// nil if none was saved there or it's since been pruned.
+	GetSnapshot(ctx context.Context, height uint64) (*state.Snapshot, error)
+	// ListSnapshotHeights returns every height at which a snapshot is
+	// currently retained, in no particular order.
+	ListSnapshotHeights(ctx context.Context) ([]uint64, error)
+	// DeleteSnapshot removes the snapshot retained at height, if any.
+	DeleteSnapshot(ctx context.Context, height uint64) error
 }
 
 // Pool provides storage for transactions in the pending
@@ -93,6 +131,16 @@ type Pool interface {
 	// It is required to be idempotent.
 	Insert(context.Context, *bc.Tx) error
 
+	// InsertWithToken is like Insert, but remembers clientToken so a
+	// retried call with the same token is idempotent even if the pool
+	// has already forgotten tx itself (e.g. because it landed in a
+	// block). If clientToken was already used for a different tx,
+	// InsertWithToken returns that tx's hash and ErrClientTokenConflict
+	// instead of inserting tx. If it was already used for the same tx,
+	// it returns that tx's hash and a nil error without inserting
+	// anything new. Otherwise it inserts tx and returns a nil hash.
+	InsertWithToken(ctx context.Context, tx *bc.Tx, clientToken string) (existingHash *bc.Hash, err error)
+
 	// Dump wipes the pending transaction pool and returns all
 	// transactions that were in the pool.
 	Dump(context.Context) ([]*bc.Tx, error)
@@ -107,19 +155,53 @@ type Chain struct {
 	MaxIssuanceWindow time.Duration // only used by generators
 
 	blockCallbacks []BlockCallback
+	reorgCallbacks []ReorgCallback
 	state          struct {
 		cond     sync.Cond // protects height, block, snapshot
 		height   uint64
 		block    *bc.Block       // current only if leader
 		snapshot *state.Snapshot // current only if leader
 	}
-	store Store
-	pool  Pool
+	store  Store
+	pool   Pool
+	tokens *tokenCache
 
 	lastQueuedSnapshot time.Time
 	pendingSnapshots   chan pendingSnapshot
 
 	prevalidated prevalidatedTxsCache
+
+	// forkMu protects forkChoice, heads, children, snapshots and the
+	// finalized* fields below. It's a separate lock from state.cond.L
+	// because fork bookkeeping updates can run well ahead of (or
+	// behind) which block is the current leader state.
+	forkMu     sync.Mutex
+	forkChoice ForkChoiceFunc
+	heads      map[bc.Hash]*bc.Block       // candidate tips, keyed by their own hash
+	children   map[bc.Hash][]bc.Hash       // parent hash -> known child hashes
+	snapshots  map[bc.Hash]*state.Snapshot // post-state for every known, non-pruned block
+
+	finalizedHeight uint64
+	finalizedHash   bc.Hash
+
+	feeds feeds
+
+	// pendingMu protects pendingBlock, pendingSnapshot and
+	// pendingTxs: the block a local proposer is currently
+	// assembling, together with its speculative post-state.
+	pendingMu       sync.Mutex
+	pendingBlock    *bc.Block
+	pendingSnapshot *state.Snapshot
+	pendingTxs      []*bc.Tx
+
+	// headerStore is set only for a Chain created with NewLightChain;
+	// its presence is what distinguishes a light chain from a full
+	// one. witnessGen is used by full nodes to produce the
+	// WitnessData light peers need; it's nil on a light chain.
+	headerStore HeaderStore
+	witnessGen  WitnessGenerator
+
+	gc *snapshotGC
 }
 
 type pendingSnapshot struct {
@@ -128,7 +210,10 @@ type pendingSnapshot struct {
 }
 
 // NewChain returns a new Chain using store as the underlying storage.
-func NewChain(ctx context.Context, initialBlockHash bc.Hash, store Store, pool Pool, heights <-chan uint64) (*Chain, error) {
+// policy controls how many historical state snapshots are retained;
+// a nil policy disables snapshot garbage collection entirely, which
+// is the old, unbounded-retention behavior.
+func NewChain(ctx context.Context, initialBlockHash bc.Hash, store Store, pool Pool, heights <-chan uint64, policy *SnapshotPolicy) (*Chain, error) {
 	c := &Chain{
 		InitialBlockHash: initialBlockHash,
 		store:            store,
@@ -137,6 +222,12 @@ func NewChain(ctx context.Context, initialBlockHash bc.Hash, store Store, pool P
 		prevalidated: prevalidatedTxsCache{
 			lru: lru.New(maxCachedValidatedTxs),
 		},
+		forkChoice: longestChain,
+		heads:      make(map[bc.Hash]*bc.Block),
+		children:   make(map[bc.Hash][]bc.Hash),
+		snapshots:  make(map[bc.Hash]*state.Snapshot),
+		gc:         newSnapshotGC(policy),
+		tokens:     newTokenCache(maxCachedClientTokens, defaultClientTokenTTL),
 	}
 	c.state.cond.L = new(sync.Mutex)
 
@@ -145,6 +236,35 @@ func NewChain(ctx context.Context, initialBlockHash bc.Hash, store Store, pool P
 	if err != nil {
 		return nil, errors.Wrap(err, "looking up blockchain height")
 	}
+	c.finalizedHeight = c.state.height
+
+	heads, err := store.Heads(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading head candidates")
+	}
+	for _, h := range heads {
+		c.heads[h.Hash()] = h
+	}
+	if len(heads) == 1 {
+		c.finalizedHash = heads[0].Hash()
+	}
+
+	err = c.loadPendingBlock(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "reloading in-flight pending block")
+	}
+
+	if policy != nil {
+		retained, err := store.ListSnapshotHeights(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing retained snapshot heights")
+		}
+		for _, h := range retained {
+			c.gc.present[h] = true
+			c.gc.heights = append(c.gc.heights, h)
+		}
+		heap.Init(&c.gc.heights)
+	}
 
 	// Note that c.height.n may still be zero here.
 	if heights != nil {
@@ -164,6 +284,11 @@ func NewChain(ctx context.Context, initialBlockHash bc.Hash, store Store, pool P
 				err = store.SaveSnapshot(ctx, ps.height, ps.snapshot)
 				if err != nil {
 					log.Error(ctx, err, "at", "saving snapshot")
+					continue
+				}
+				err = c.gc.track(ctx, store, ps.height, snapshotByteSize(ps.snapshot))
+				if err != nil {
+					log.Error(ctx, err, "at", "pruning snapshots")
 				}
 			}
 		}
@@ -204,10 +329,33 @@ func (c *Chain) setState(b *bc.Block, s *state.Snapshot) {
 	}
 }
 
+// AddBlockCallback registers f to be called, in registration order,
+// for every block CommitBlock makes newly canonical -- by simple
+// extension, or one call per block in a reorg's applied branch,
+// ancestor to tip. If f returns an error, CommitBlock stops running
+// callbacks for the remaining blocks and returns that error.
 func (c *Chain) AddBlockCallback(f BlockCallback) {
 	c.blockCallbacks = append(c.blockCallbacks, f)
 }
 
+// AddReorgCallback registers f to be called whenever the fork-choice
+// rule switches the canonical head away from its previous block.
+func (c *Chain) AddReorgCallback(f ReorgCallback) {
+	c.reorgCallbacks = append(c.reorgCallbacks, f)
+}
+
+// SetForkChoice installs f as the chain's fork-choice rule. f is
+// consulted on every CommitBlock call, even one that simply extends
+// the current canonical tip, and it must pick one of the given
+// candidates (every known head; see ForkChoiceFunc) as the new
+// canonical head. The default rule prefers the candidate with the
+// greatest height.
+func (c *Chain) SetForkChoice(f ForkChoiceFunc) {
+	c.forkMu.Lock()
+	defer c.forkMu.Unlock()
+	c.forkChoice = f
+}
+
 // WaitForBlockSoon waits for the block at the given height,
 // but it is an error to wait for a block far in the future.
 // WaitForBlockSoon will timeout if the context times out.
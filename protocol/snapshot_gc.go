@@ -0,0 +1,251 @@
+package protocol
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/gob"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chain/errors"
+	"chain/log"
+	"chain/protocol/bc"
+	"chain/protocol/state"
+	"chain/protocol/validation"
+)
+
+// SnapshotPolicy controls how many historical state snapshots a
+// Chain retains via its Store. Snapshots outside the policy are
+// pruned in the background as new ones are saved. A nil
+// *SnapshotPolicy (the default, if NewChain isn't given one) disables
+// pruning entirely: every snapshot ever saved is kept.
+type SnapshotPolicy struct {
+	// KeepEvery retains one snapshot out of every KeepEvery blocks
+	// (e.g. 1000 keeps heights 1000, 2000, 3000, ...), subject to
+	// MaxBytes: a kept height is still evicted if the budget can't
+	// otherwise be met. Zero means this rule keeps nothing.
+	KeepEvery uint64
+
+	// KeepRecent always retains every snapshot within KeepRecent
+	// blocks of the newest one (heights newest-KeepRecent+1 through
+	// newest), regardless of KeepEvery -- but, like KeepEvery, not
+	// regardless of MaxBytes.
+	KeepRecent uint64
+
+	// MaxBytes bounds the total size of retained snapshots. Once
+	// exceeded, the oldest snapshot is pruned first, even one
+	// KeepEvery or KeepRecent would otherwise retain: MaxBytes is a
+	// hard cap, not just a tie-breaker among evictable snapshots.
+	// Zero means unbounded.
+	MaxBytes int64
+}
+
+// snapshotGC tracks every snapshot height a Chain knows about and
+// evicts the ones SnapshotPolicy no longer wants retained. Heights
+// are kept in a min-heap (oldest first), mirroring the triegc
+// priority queue go-ethereum uses to decide which state tries to
+// dereference.
+type snapshotGC struct {
+	policy *SnapshotPolicy
+
+	mu          sync.Mutex
+	heights     snapshotHeap
+	present     map[uint64]bool
+	bytesByHash map[uint64]int64
+	totalBytes  int64
+
+	reconstructNanos int64 // atomic: duration of the last SnapshotAt rebuild
+}
+
+type snapshotHeap []uint64
+
+func (h snapshotHeap) Len() int            { return len(h) }
+func (h snapshotHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h snapshotHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *snapshotHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+func (h *snapshotHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	v := old[n-1]
+	*h = old[:n-1]
+	return v
+}
+
+// snapshotByteSize estimates how many bytes a snapshot occupies on
+// disk, for MaxBytes accounting. It's a var so tests (and, later, a
+// Store-specific implementation) can supply a real size without this
+// package needing to know the Store's on-disk encoding. The default
+// gob-encodes s as a stand-in for that on-disk encoding; it's an
+// estimate, not a guarantee of the Store's actual footprint.
+var snapshotByteSize = func(s *state.Snapshot) int64 {
+	if s == nil {
+		return 0
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(s)
+	if err != nil {
+		return 0
+	}
+	return int64(buf.Len())
+}
+
+func newSnapshotGC(policy *SnapshotPolicy) *snapshotGC {
+	return &snapshotGC{
+		policy:      policy,
+		present:     make(map[uint64]bool),
+		bytesByHash: make(map[uint64]int64),
+	}
+}
+
+// track records that a snapshot at height has just been saved, sized
+// approximately nbytes, and runs eviction if the Chain's
+// SnapshotPolicy is exceeded.
+func (gc *snapshotGC) track(ctx context.Context, store Store, height uint64, nbytes int64) error {
+	if gc.policy == nil {
+		return nil
+	}
+
+	gc.mu.Lock()
+	if !gc.present[height] {
+		gc.present[height] = true
+		heap.Push(&gc.heights, height)
+	}
+	gc.totalBytes += nbytes - gc.bytesByHash[height]
+	gc.bytesByHash[height] = nbytes
+	evict := gc.evictable(height)
+	gc.mu.Unlock()
+
+	for _, h := range evict {
+		err := store.DeleteSnapshot(ctx, h)
+		if err != nil {
+			return errors.Wrap(err, "deleting evicted snapshot")
+		}
+		log.Printf(ctx, "snapshot gc: pruned snapshot at height %d", h)
+	}
+	return nil
+}
+
+// evictable removes from the heap every snapshot height that
+// SnapshotPolicy no longer wants retained, given newest is the most
+// recently saved height, and returns them oldest first. A height that
+// keep reports as protected is still evicted while the budget remains
+// exceeded -- MaxBytes is a hard cap that overrides KeepEvery and
+// KeepRecent, not just a tie-breaker among heights they don't
+// protect. It must be called with gc.mu held, and mutates gc's
+// bookkeeping for each height it evicts.
+func (gc *snapshotGC) evictable(newest uint64) []uint64 {
+	var evicted, kept []uint64
+	for gc.heights.Len() > 0 {
+		oldest := heap.Pop(&gc.heights).(uint64)
+		if gc.keep(oldest, newest) && !gc.overBudget() {
+			// Protected heights go back on the heap below; newer
+			// heights behind them still need checking.
+			kept = append(kept, oldest)
+			continue
+		}
+		delete(gc.present, oldest)
+		gc.totalBytes -= gc.bytesByHash[oldest]
+		delete(gc.bytesByHash, oldest)
+		evicted = append(evicted, oldest)
+	}
+	for _, h := range kept {
+		heap.Push(&gc.heights, h)
+	}
+	return evicted
+}
+
+func (gc *snapshotGC) keep(height, newest uint64) bool {
+	p := gc.policy
+	if p.KeepRecent > 0 && height+p.KeepRecent > newest {
+		return true
+	}
+	if p.KeepEvery > 0 && height%p.KeepEvery == 0 {
+		return true
+	}
+	return false
+}
+
+func (gc *snapshotGC) overBudget() bool {
+	return gc.policy.MaxBytes > 0 && gc.totalBytes > gc.policy.MaxBytes
+}
+
+// SnapshotBytes returns the approximate total size of every snapshot this
+// Chain currently believes is retained.
+func (c *Chain) SnapshotBytes() int64 {
+	c.gc.mu.Lock()
+	defer c.gc.mu.Unlock()
+	return c.gc.totalBytes
+}
+
+// SnapshotReconstructTime returns how long the most recent call to
+// SnapshotAt spent rebuilding a snapshot that wasn't retained
+// verbatim. It's zero if no rebuild has happened yet.
+func (c *Chain) SnapshotReconstructTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.gc.reconstructNanos))
+}
+
+// SnapshotAt returns the state snapshot as of height. If Store
+// retained that exact snapshot, it's returned directly; otherwise
+// SnapshotAt loads the nearest retained snapshot at or before height
+// and reconstructs the target by replaying blocks forward.
+func (c *Chain) SnapshotAt(ctx context.Context, height uint64) (*state.Snapshot, error) {
+	snap, err := c.store.GetSnapshot(ctx, height)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading snapshot")
+	}
+	if snap != nil {
+		return snap.Copy(), nil
+	}
+
+	start := time.Now()
+
+	heights, err := c.store.ListSnapshotHeights(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing retained snapshot heights")
+	}
+	var base uint64
+	found := false
+	for _, h := range heights {
+		if h <= height && (!found || h > base) {
+			base, found = h, true
+		}
+	}
+	if !found {
+		return nil, errors.New("no retained snapshot at or before requested height")
+	}
+
+	snap, err = c.store.GetSnapshot(ctx, base)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading base snapshot")
+	}
+	snap = snap.Copy()
+
+	for h := base + 1; h <= height; h++ {
+		block, err := c.store.GetBlock(ctx, h)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading block")
+		}
+		if block == nil {
+			return nil, errors.New("missing block while reconstructing snapshot")
+		}
+		err = applyBlock(snap, block)
+		if err != nil {
+			return nil, errors.Wrap(err, "replaying block")
+		}
+	}
+
+	atomic.StoreInt64(&c.gc.reconstructNanos, int64(time.Since(start)))
+	return snap, nil
+}
+
+func applyBlock(snapshot *state.Snapshot, block *bc.Block) error {
+	for _, tx := range block.Transactions {
+		err := validation.ApplyTx(snapshot, tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
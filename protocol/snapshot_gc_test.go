@@ -0,0 +1,202 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+
+	"chain/protocol/bc"
+	"chain/protocol/state"
+)
+
+func TestSnapshotGCRetention(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	store.height = 1
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &SnapshotPolicy{KeepEvery: 10, KeepRecent: 2}
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, genesis, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := genesis
+	for h := uint64(2); h <= 12; h++ {
+		blk := testBlock(h, prev.Hash(), byte(h))
+		snap := &state.Snapshot{Height: h}
+		if err := c.CommitBlock(ctx, blk, snap); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveSnapshot(ctx, h, snap); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.gc.track(ctx, store, h, 0); err != nil {
+			t.Fatal(err)
+		}
+		prev = blk
+	}
+
+	// Height 10 matches KeepEvery; heights 11-12 are within
+	// KeepRecent of the newest (12); everything else should have
+	// been pruned.
+	for _, h := range []uint64{10, 11, 12} {
+		if _, ok := store.snapshots[h]; !ok {
+			t.Errorf("expected snapshot at height %d to be retained", h)
+		}
+	}
+	for _, h := range []uint64{2, 3, 8, 9} {
+		if _, ok := store.snapshots[h]; ok {
+			t.Errorf("expected snapshot at height %d to be pruned", h)
+		}
+	}
+}
+
+func TestSnapshotByteSize(t *testing.T) {
+	if got := snapshotByteSize(nil); got != 0 {
+		t.Errorf("snapshotByteSize(nil) = %d, want 0", got)
+	}
+	empty := snapshotByteSize(&state.Snapshot{})
+	bigger := snapshotByteSize(&state.Snapshot{Height: 1 << 32})
+	if empty <= 0 {
+		t.Errorf("snapshotByteSize(&state.Snapshot{}) = %d, want > 0", empty)
+	}
+	if bigger <= empty {
+		t.Errorf("snapshotByteSize grew from %d to %d for a larger snapshot, want strictly larger", empty, bigger)
+	}
+}
+
+func TestSnapshotGCMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	store.height = 1
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each tracked snapshot here gob-encodes to the same size;
+	// half again as much comfortably holds one but not two.
+	oneSnapshot := snapshotByteSize(&state.Snapshot{Height: 2})
+	policy := &SnapshotPolicy{KeepRecent: 1, MaxBytes: oneSnapshot + oneSnapshot/2}
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, genesis, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := genesis
+	for h := uint64(2); h <= 4; h++ {
+		blk := testBlock(h, prev.Hash(), byte(h))
+		snap := &state.Snapshot{Height: h}
+		if err := c.CommitBlock(ctx, blk, snap); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveSnapshot(ctx, h, snap); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.gc.track(ctx, store, h, snapshotByteSize(snap)); err != nil {
+			t.Fatal(err)
+		}
+		prev = blk
+	}
+
+	// A MaxBytes of one and a half snapshots can't hold two, so
+	// only the most recently tracked height should survive.
+	if _, ok := store.snapshots[4]; !ok {
+		t.Error("expected the newest snapshot to be retained despite MaxBytes")
+	}
+	for _, h := range []uint64{2, 3} {
+		if _, ok := store.snapshots[h]; ok {
+			t.Errorf("expected snapshot at height %d to be pruned once MaxBytes was exceeded", h)
+		}
+	}
+	if got := c.SnapshotBytes(); got != snapshotByteSize(&state.Snapshot{Height: 4}) {
+		t.Errorf("SnapshotBytes() = %d, want exactly the retained snapshot's size", got)
+	}
+}
+
+// TestSnapshotAtCopiesRetainedSnapshot confirms that when Store
+// retained the exact snapshot requested, SnapshotAt hands the caller a
+// copy rather than the Store's own retained snapshot -- a caller that
+// mutates the result must not corrupt what a later SnapshotAt call (or
+// the Store itself) sees.
+func TestSnapshotAtCopiesRetainedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	store.height = 1
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+	store.snapshots[1] = &state.Snapshot{Height: 1}
+
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, genesis, store.snapshots[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.SnapshotAt(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == store.snapshots[1] {
+		t.Fatal("expected SnapshotAt to return a copy, not the Store's retained snapshot")
+	}
+
+	got.Height = 99
+	if store.snapshots[1].Height != 1 {
+		t.Errorf("mutating the returned snapshot corrupted the Store's retained snapshot, height = %d", store.snapshots[1].Height)
+	}
+}
+
+func TestSnapshotAtReconstructs(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	store.height = 1
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+	store.snapshots[1] = &state.Snapshot{Height: 1}
+
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, genesis, store.snapshots[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	next := testBlock(2, genesis.Hash(), 1)
+	if err := store.SaveBlock(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, next, &state.Snapshot{Height: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No snapshot was ever saved at height 2, so SnapshotAt must
+	// reconstruct it from the retained snapshot at height 1.
+	got, err := c.SnapshotAt(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("expected a reconstructed snapshot")
+	}
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+
+	"chain/errors"
+	"chain/protocol/bc"
+)
+
+// maxCachedClientTokens bounds how many client tokens Chain remembers
+// locally, mirroring maxCachedValidatedTxs.
+const maxCachedClientTokens = 10000
+
+// defaultClientTokenTTL is how long a client token is remembered if
+// NewChain's caller doesn't override it with SetClientTokenTTL. It's
+// meant to comfortably outlast any reasonable client retry loop
+// without holding tokens forever.
+const defaultClientTokenTTL = 24 * time.Hour
+
+// ErrClientTokenConflict is returned by AddTx when a caller reuses a
+// ClientToken that was already registered against a different
+// transaction, and asks to be told about it via RejectIfConflict.
+var ErrClientTokenConflict = errors.New("client token already used for a different transaction")
+
+// AddTxOptions customizes how AddTx admits a transaction into the
+// pending pool.
+type AddTxOptions struct {
+	// ClientToken, if set, makes AddTx idempotent: retrying the same
+	// tx with the same token is a safe no-op rather than a duplicate
+	// pool entry. The token is scoped to the caller; use something
+	// like a UUID generated once per logical submission attempt.
+	ClientToken string
+
+	// RejectIfConflict, if true, makes AddTx return
+	// ErrClientTokenConflict when ClientToken was already used for a
+	// different transaction, instead of silently treating the earlier
+	// transaction as the winner.
+	RejectIfConflict bool
+}
+
+// AddTx adds tx to the pending transaction pool and publishes it on
+// the PendingTxFeed. It doesn't validate tx; callers are expected to
+// have already run it through package validation.
+//
+// If opts carries a ClientToken, AddTx is idempotent with respect to
+// that token: a retry that reuses it, whether because the caller
+// never saw the first attempt's response or is replaying after a
+// crash, returns the result of the original attempt rather than
+// inserting tx a second time.
+func (c *Chain) AddTx(ctx context.Context, tx *bc.Tx, opts ...AddTxOptions) error {
+	var opt AddTxOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	if opt.ClientToken == "" {
+		err := c.pool.Insert(ctx, tx)
+		if err != nil {
+			return errors.Wrap(err, "inserting tx into pool")
+		}
+	} else {
+		if hash, ok := c.tokens.get(opt.ClientToken); ok && hash == tx.Hash {
+			// Already admitted under this token; nothing left to do.
+			return nil
+		}
+
+		existingHash, err := c.pool.InsertWithToken(ctx, tx, opt.ClientToken)
+		if err != nil && err != ErrClientTokenConflict {
+			return errors.Wrap(err, "inserting tx into pool")
+		}
+		if existingHash != nil {
+			c.tokens.set(opt.ClientToken, *existingHash)
+			if err == ErrClientTokenConflict && opt.RejectIfConflict {
+				return err
+			}
+			return nil
+		}
+		c.tokens.set(opt.ClientToken, tx.Hash)
+	}
+
+	c.feeds.pendingTx.Send(tx)
+	c.addToPending(ctx, tx)
+	return nil
+}
+
+// notifyCommittedTxs publishes a RemovedTx event with RemoveCommitted
+// for every transaction in block, so callers driving a retry loop off
+// RemovedTxFeed learn their tx landed on chain without having to poll
+// for it.
+func (c *Chain) notifyCommittedTxs(ctx context.Context, block *bc.Block) {
+	for _, tx := range block.Transactions {
+		c.feeds.removedTx.Send(RemovedTx{Tx: tx, Reason: RemoveCommitted})
+	}
+}
+
+// tokenCache remembers recently-seen client tokens and the tx hash
+// each was accepted under. It's bounded by both an entry count (via
+// an LRU) and a per-entry TTL, so a node handling a steady stream of
+// one-shot idempotency keys doesn't grow this cache forever. It's
+// purely an optimization: the Pool remains the source of truth for
+// whether a token has already been used.
+type tokenCache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	lru *lru.Cache
+}
+
+type tokenCacheEntry struct {
+	hash    bc.Hash
+	expires time.Time
+}
+
+func newTokenCache(maxEntries int, ttl time.Duration) *tokenCache {
+	return &tokenCache{ttl: ttl, lru: lru.New(maxEntries)}
+}
+
+func (tc *tokenCache) get(token string) (bc.Hash, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	v, ok := tc.lru.Get(token)
+	if !ok {
+		return bc.Hash{}, false
+	}
+	entry := v.(tokenCacheEntry)
+	if time.Now().After(entry.expires) {
+		tc.lru.Remove(token)
+		return bc.Hash{}, false
+	}
+	return entry.hash, true
+}
+
+func (tc *tokenCache) set(token string, hash bc.Hash) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.lru.Add(token, tokenCacheEntry{hash: hash, expires: time.Now().Add(tc.ttl)})
+}
+
+// SetClientTokenTTL overrides how long AddTx remembers a ClientToken
+// before it's eligible for eviction. It has no effect on tokens
+// already cached.
+func (c *Chain) SetClientTokenTTL(ttl time.Duration) {
+	c.tokens.mu.Lock()
+	defer c.tokens.mu.Unlock()
+	c.tokens.ttl = ttl
+}
@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chain/protocol/bc"
+	"chain/protocol/state"
+	"chain/protocol/validation"
+)
+
+// memPool is a minimal in-memory Pool used to exercise client-token
+// idempotency without a real mempool.
+type memPool struct {
+	txs    map[bc.Hash]*bc.Tx
+	tokens map[string]bc.Hash
+}
+
+func newMemPool() *memPool {
+	return &memPool{
+		txs:    make(map[bc.Hash]*bc.Tx),
+		tokens: make(map[string]bc.Hash),
+	}
+}
+
+func (p *memPool) Insert(ctx context.Context, tx *bc.Tx) error {
+	p.txs[tx.Hash] = tx
+	return nil
+}
+
+func (p *memPool) InsertWithToken(ctx context.Context, tx *bc.Tx, clientToken string) (*bc.Hash, error) {
+	if hash, ok := p.tokens[clientToken]; ok {
+		if hash != tx.Hash {
+			return &hash, ErrClientTokenConflict
+		}
+		return &hash, nil
+	}
+	p.tokens[clientToken] = tx.Hash
+	return nil, p.Insert(ctx, tx)
+}
+
+func (p *memPool) Dump(ctx context.Context) ([]*bc.Tx, error) {
+	var txs []*bc.Tx
+	for _, tx := range p.txs {
+		txs = append(txs, tx)
+	}
+	p.txs = make(map[bc.Hash]*bc.Tx)
+	return txs, nil
+}
+
+func TestAddTxClientTokenIdempotent(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	pool := newMemPool()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	store.height = 1
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewChain(ctx, genesis.Hash(), store, pool, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, genesis, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &bc.Tx{Hash: bc.Hash{0x1}}
+	opts := AddTxOptions{ClientToken: "retry-me"}
+	if err := c.AddTx(ctx, tx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddTx(ctx, tx, opts); err != nil {
+		t.Fatalf("retrying the same tx under the same token should be a no-op, got %v", err)
+	}
+	if len(c.PendingTxs()) != 1 {
+		t.Fatalf("expected exactly one pending tx, got %d", len(c.PendingTxs()))
+	}
+
+	other := &bc.Tx{Hash: bc.Hash{0x2}}
+	if err := c.AddTx(ctx, other, AddTxOptions{ClientToken: "retry-me"}); err != nil {
+		t.Fatalf("reusing a token against a different tx without RejectIfConflict should be absorbed, got %v", err)
+	}
+	if len(c.PendingTxs()) != 1 {
+		t.Fatalf("conflicting tx should not have been admitted, pending txs = %d", len(c.PendingTxs()))
+	}
+
+	err = c.AddTx(ctx, other, AddTxOptions{ClientToken: "retry-me", RejectIfConflict: true})
+	if err != ErrClientTokenConflict {
+		t.Fatalf("expected ErrClientTokenConflict, got %v", err)
+	}
+}
+
+func TestCommitBlockNotifiesRemovedTxs(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	store.height = 1
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewChain(ctx, genesis.Hash(), store, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan RemovedTx, 1)
+	sub := c.RemovedTxFeed().Subscribe(ch)
+	defer sub.Unsubscribe()
+
+	tx := &bc.Tx{Hash: bc.Hash{0x1}}
+	genesis.Transactions = []*bc.Tx{tx}
+	if err := c.CommitBlock(ctx, genesis, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case removed := <-ch:
+		if removed.Tx.Hash != tx.Hash {
+			t.Errorf("removed tx hash = %v, want %v", removed.Tx.Hash, tx.Hash)
+		}
+		if removed.Reason != RemoveCommitted {
+			t.Errorf("removed reason = %v, want RemoveCommitted", removed.Reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a RemovedTx notification after CommitBlock")
+	}
+}
+
+// TestCommitBlockNotifiesConflictingPendingTx confirms that when a
+// block commits a tx that double-spends an output also spent by a
+// locally pending tx, the losing pending tx is reported on
+// RemovedTxFeed with RemoveConflict once its pending block is reset.
+func TestCommitBlockNotifiesConflictingPendingTx(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	genesis := testBlock(1, bc.Hash{}, 0)
+	store.height = 1
+	if err := store.SaveBlock(ctx, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewChain(ctx, genesis.Hash(), store, newMemPool(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, genesis, &state.Snapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	spent := bc.Hash{0xaa}
+	pendingTx := &bc.Tx{Hash: bc.Hash{0x1}, Spends: []bc.Hash{spent}}
+	if err := c.AddTx(ctx, pendingTx); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan RemovedTx, 2)
+	sub := c.RemovedTxFeed().Subscribe(ch)
+	defer sub.Unsubscribe()
+
+	winningTx := &bc.Tx{Hash: bc.Hash{0x2}, Spends: []bc.Hash{spent}}
+	next := testBlock(2, genesis.Hash(), 1)
+	next.Transactions = []*bc.Tx{winningTx}
+
+	// nextSnapshot is the post-state CommitBlock's caller is
+	// responsible for supplying: genesis's snapshot with winningTx
+	// already applied.
+	nextSnapshot := (&state.Snapshot{}).Copy()
+	if err := validation.ApplyTx(nextSnapshot, winningTx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CommitBlock(ctx, next, nextSnapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotCommitted, gotConflict bool
+	for i := 0; i < 2; i++ {
+		select {
+		case removed := <-ch:
+			switch removed.Tx.Hash {
+			case winningTx.Hash:
+				if removed.Reason != RemoveCommitted {
+					t.Errorf("removed reason for winning tx = %v, want RemoveCommitted", removed.Reason)
+				}
+				gotCommitted = true
+			case pendingTx.Hash:
+				if removed.Reason != RemoveConflict {
+					t.Errorf("removed reason for losing pending tx = %v, want RemoveConflict", removed.Reason)
+				}
+				gotConflict = true
+			default:
+				t.Errorf("unexpected removed tx hash %v", removed.Tx.Hash)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected two RemovedTx notifications after CommitBlock")
+		}
+	}
+	if !gotCommitted || !gotConflict {
+		t.Fatalf("expected both a RemoveCommitted and a RemoveConflict notification, got committed=%v conflict=%v", gotCommitted, gotConflict)
+	}
+}